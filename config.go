@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"gopkg.in/yaml.v3"
+)
+
+// NetworkConfig describes the FITS mapping for a single network: how
+// Hilltop site names and data sources translate to FITS site/type IDs,
+// per-measurement method overrides, and the uncertainty to record when
+// Hilltop doesn't provide one.
+type NetworkConfig struct {
+	Sites   map[string]string `yaml:"sites"`   // Hilltop SiteName -> FITS SiteID
+	Types   map[string]string `yaml:"types"`   // Hilltop DataSource.Name -> FITS TypeID, overrides HilltopUnits
+	Methods map[string]string `yaml:"methods"` // Hilltop DataSource.Name -> FITS MethodID, overrides the -method flag
+	Error   float64           `yaml:"error"`   // default uncertainty recorded against each observation
+}
+
+// Config is the top level of a `-config fits.yaml` file: one
+// NetworkConfig per FITS network.
+type Config struct {
+	Networks map[string]NetworkConfig `yaml:"networks"`
+}
+
+// LoadConfig reads and validates a config file, returning an error that
+// names the offending line when the YAML is structurally invalid.
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config: %s [%s]", path, err)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(b, &root); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	var c Config
+	if err := root.Decode(&c); err != nil {
+		return nil, fmt.Errorf("%s:%d: %s", path, root.Line, err)
+	}
+
+	if err := c.validate(path, &root); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// validate checks that no network maps a Hilltop site or data source to an
+// empty FITS site or type, and reports the originating line when one does.
+func (c *Config) validate(path string, root *yaml.Node) error {
+	for name, n := range c.Networks {
+		for hilltopName, typeID := range n.Types {
+			if typeID == "" {
+				return fmt.Errorf("%s:%d: network %q: empty FITS type for hilltop data source %q", path, lineOf(root, "networks", name, "types", hilltopName), name, hilltopName)
+			}
+		}
+		for hilltopName, siteID := range n.Sites {
+			if siteID == "" {
+				return fmt.Errorf("%s:%d: network %q: empty FITS site for hilltop site %q", path, lineOf(root, "networks", name, "sites", hilltopName), name, hilltopName)
+			}
+		}
+	}
+
+	return nil
+}
+
+// lineOf walks a yaml.Node document by mapping keys and returns the line
+// number of the deepest key found, or the document's own line as a
+// fallback so error messages always point somewhere useful.
+func lineOf(root *yaml.Node, path ...string) int {
+	n := root
+	if len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+	line := n.Line
+
+	for _, key := range path {
+		found := false
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == key {
+				line = n.Content[i].Line
+				n = n.Content[i+1]
+				found = true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+	}
+
+	return line
+}
+
+// Resolve looks up the FITS identifiers for an observation from network,
+// falling back to defaultSiteID/defaultTypeID/defaultMethod/defaultError
+// (the -site flags, HilltopUnits, and the -method flag) when the config
+// has no network or no override for the given names.
+func (c *Config) Resolve(network, hilltopSite, hilltopDataSource, defaultSiteID, defaultTypeID, defaultMethod string, defaultError float64) (siteID, typeID, method string, errVal float64, ok bool) {
+	siteID, typeID, method, errVal = defaultSiteID, defaultTypeID, defaultMethod, defaultError
+
+	n, hasNetwork := c.Networks[network]
+	if !hasNetwork {
+		return siteID, typeID, method, errVal, defaultSiteID != "" && defaultTypeID != ""
+	}
+
+	if s, ok := n.Sites[hilltopSite]; ok {
+		siteID = s
+	}
+	if t, ok := n.Types[hilltopDataSource]; ok {
+		typeID = t
+	}
+	if m, ok := n.Methods[hilltopDataSource]; ok {
+		method = m
+	}
+	if n.Error != 0 {
+		errVal = n.Error
+	}
+
+	return siteID, typeID, method, errVal, siteID != "" && typeID != ""
+}
+
+// ConfigStore holds the active Config and supports SIGHUP-triggered hot
+// reload so a long-running -watch collector can pick up new sites or
+// data-source mappings without restarting.
+type ConfigStore struct {
+	mu   sync.RWMutex
+	path string
+	cfg  *Config
+}
+
+// NewConfigStore loads path (which may be empty, giving an always-empty
+// Config so callers fall back entirely to flags and HilltopUnits).
+func NewConfigStore(path string) (*ConfigStore, error) {
+	s := &ConfigStore{path: path, cfg: &Config{}}
+	if path == "" {
+		return s, nil
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	s.cfg = cfg
+
+	return s, nil
+}
+
+// Get returns the currently active Config.
+func (s *ConfigStore) Get() *Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cfg
+}
+
+// WatchReload reloads the config file whenever the process receives
+// SIGHUP, logging and keeping the previous Config on a parse failure so a
+// bad edit can't take down a running collector.
+func (s *ConfigStore) WatchReload() {
+	if s.path == "" {
+		return
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			cfg, err := LoadConfig(s.path)
+			if err != nil {
+				Log.Error().Err(err).Str("config", s.path).Msg("config reload failed, keeping previous config")
+				continue
+			}
+
+			s.mu.Lock()
+			s.cfg = cfg
+			s.mu.Unlock()
+			Log.Info().Str("config", s.path).Msg("config reloaded")
+		}
+	}()
+}
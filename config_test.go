@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestLineOf checks that lineOf walks nested mapping keys to the line the
+// deepest one occurs on, and stops at the deepest key that does exist when
+// a later key in the path doesn't.
+func TestLineOf(t *testing.T) {
+	const doc = `networks:
+  CG:
+    types:
+      Rainfall: ""
+    sites:
+      SiteX: "123"
+`
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(doc), &root); err != nil {
+		t.Fatalf("unable to parse test yaml: %s", err)
+	}
+
+	if got := lineOf(&root, "networks", "CG", "types", "Rainfall"); got != 4 {
+		t.Errorf("lineOf(types/Rainfall) = %d, want 4", got)
+	}
+	if got := lineOf(&root, "networks", "CG", "sites", "SiteX"); got != 6 {
+		t.Errorf("lineOf(sites/SiteX) = %d, want 6", got)
+	}
+	if got, want := lineOf(&root, "networks", "CG"), 2; got != want {
+		t.Errorf("lineOf(networks/CG) = %d, want %d", got, want)
+	}
+	if got := lineOf(&root, "networks", "missing", "types", "Rainfall"); got != 1 {
+		t.Errorf("lineOf with a missing key = %d, want the line of \"networks\" (1)", got)
+	}
+}
+
+// TestLoadConfig_InvalidReportsLine checks that an empty FITS type mapping
+// is rejected with an error naming the line of the offending entry.
+func TestLoadConfig_InvalidReportsLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fits.yaml")
+	const doc = `networks:
+  CG:
+    types:
+      Rainfall: ""
+`
+	if err := os.WriteFile(path, []byte(doc), 0644); err != nil {
+		t.Fatalf("unable to write test config: %s", err)
+	}
+
+	_, err := LoadConfig(path)
+	if err == nil {
+		t.Fatal("LoadConfig: expected an error for an empty type mapping, got nil")
+	}
+	if !strings.Contains(err.Error(), ":4:") {
+		t.Errorf("LoadConfig error = %q, want it to name line 4", err)
+	}
+}
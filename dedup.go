@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/GeoNet/msg"
+	"go.etcd.io/bbolt"
+)
+
+// DedupStore remembers observations that have already been delivered so
+// that re-processing overlapping Hilltop XML does not emit duplicates.
+type DedupStore interface {
+	// Add records key as seen.
+	Add(key string) error
+
+	// Contains reports whether key has already been seen.
+	Contains(key string) (bool, error)
+
+	// Remove forgets key, e.g. to allow a deliberate re-send.
+	Remove(key string) error
+
+	// List returns every key currently held (mainly for diagnostics).
+	List() ([]string, error)
+
+	// Close releases any resources held by the store.
+	Close() error
+}
+
+// ObservationKey hashes the fields that make an observation unique so it
+// can be used as a DedupStore key.
+func ObservationKey(o msg.Observation) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%s", o.NetworkID, o.SiteID, o.TypeID, o.MethodID, o.DateTime.UTC().Format(time.RFC3339Nano))
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// NewDedupStore builds a DedupStore from a scheme://... URI, e.g.:
+//
+//	mem://        in-memory, TTL eviction only, no size bound (the default)
+//	bolt:///path  BoltDB backed, persists across restarts
+func NewDedupStore(raw string, ttl time.Duration) (DedupStore, error) {
+	if raw == "" {
+		raw = "mem://"
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dedup store: %s [%s]", raw, err)
+	}
+
+	switch u.Scheme {
+	case "mem", "":
+		return newMemDedupStore(ttl), nil
+	case "bolt":
+		return newBoltDedupStore(u.Path, ttl)
+	default:
+		return nil, fmt.Errorf("unknown dedup store scheme: %q", u.Scheme)
+	}
+}
+
+// memDedupStore is an in-memory, mutex-protected cache with TTL eviction.
+// It does not persist across restarts.
+type memDedupStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]time.Time
+}
+
+func newMemDedupStore(ttl time.Duration) *memDedupStore {
+	return &memDedupStore{ttl: ttl, entries: make(map[string]time.Time)}
+}
+
+func (s *memDedupStore) Add(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evictLocked()
+	s.entries[key] = time.Now()
+	return nil
+}
+
+func (s *memDedupStore) Contains(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evictLocked()
+	_, ok := s.entries[key]
+	return ok, nil
+}
+
+func (s *memDedupStore) Remove(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *memDedupStore) List() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evictLocked()
+	keys := make([]string, 0, len(s.entries))
+	for k := range s.entries {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (s *memDedupStore) Close() error { return nil }
+
+// evictLocked drops entries older than s.ttl; a ttl <= 0 disables eviction.
+// Callers must hold s.mu.
+func (s *memDedupStore) evictLocked() {
+	if s.ttl <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-s.ttl)
+	for k, t := range s.entries {
+		if t.Before(cutoff) {
+			delete(s.entries, k)
+		}
+	}
+}
+
+// dedupBucket is the single bbolt bucket boltDedupStore keeps its keys in.
+var dedupBucket = []byte("dedup")
+
+// boltDedupStore persists seen keys, each stamped with the time it was
+// added, to a BoltDB file so the dedup set survives restarts. Entries
+// older than ttl are treated as unseen rather than being proactively
+// deleted.
+type boltDedupStore struct {
+	db  *bbolt.DB
+	ttl time.Duration
+}
+
+func newBoltDedupStore(path string, ttl time.Duration) (DedupStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("bolt dedup store requires a path: bolt:///var/lib/fits/dedup.db")
+	}
+
+	db, err := bbolt.Open(path, 0644, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open bolt dedup store: %s [%s]", path, err)
+	}
+
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(dedupBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to init bolt dedup store: %s [%s]", path, err)
+	}
+
+	return &boltDedupStore{db: db, ttl: ttl}, nil
+}
+
+func (s *boltDedupStore) Add(key string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(dedupBucket).Put([]byte(key), []byte(time.Now().Format(time.RFC3339Nano)))
+	})
+}
+
+func (s *boltDedupStore) Contains(key string) (bool, error) {
+	var seen bool
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(dedupBucket).Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		if s.ttl <= 0 {
+			seen = true
+			return nil
+		}
+
+		t, err := time.Parse(time.RFC3339Nano, string(v))
+		if err != nil {
+			return err
+		}
+		seen = time.Since(t) < s.ttl
+		return nil
+	})
+	return seen, err
+}
+
+func (s *boltDedupStore) Remove(key string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(dedupBucket).Delete([]byte(key))
+	})
+}
+
+func (s *boltDedupStore) List() ([]string, error) {
+	var keys []string
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(dedupBucket).ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	return keys, err
+}
+
+func (s *boltDedupStore) Close() error { return s.db.Close() }
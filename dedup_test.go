@@ -0,0 +1,87 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestMemDedupStore_TTLEviction checks that a key is forgotten once its ttl
+// has elapsed, so a replayed observation older than the dedup window is no
+// longer treated as a duplicate.
+func TestMemDedupStore_TTLEviction(t *testing.T) {
+	s := newMemDedupStore(20 * time.Millisecond)
+
+	if err := s.Add("key"); err != nil {
+		t.Fatalf("Add: %s", err)
+	}
+	seen, err := s.Contains("key")
+	if err != nil {
+		t.Fatalf("Contains: %s", err)
+	}
+	if !seen {
+		t.Fatal("Contains(key) = false immediately after Add, want true")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	seen, err = s.Contains("key")
+	if err != nil {
+		t.Fatalf("Contains: %s", err)
+	}
+	if seen {
+		t.Fatal("Contains(key) = true after ttl elapsed, want false")
+	}
+}
+
+// TestMemDedupStore_NoTTLNeverEvicts checks that a ttl of 0 disables
+// eviction entirely, matching evictLocked's documented behaviour.
+func TestMemDedupStore_NoTTLNeverEvicts(t *testing.T) {
+	s := newMemDedupStore(0)
+
+	if err := s.Add("key"); err != nil {
+		t.Fatalf("Add: %s", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	seen, err := s.Contains("key")
+	if err != nil {
+		t.Fatalf("Contains: %s", err)
+	}
+	if !seen {
+		t.Fatal("Contains(key) = false with ttl disabled, want true")
+	}
+}
+
+// TestBoltDedupStore_TTLEviction checks the same eviction rule holds for
+// the BoltDB-backed store, which treats an expired entry as unseen rather
+// than deleting it outright.
+func TestBoltDedupStore_TTLEviction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.db")
+	store, err := newBoltDedupStore(path, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("newBoltDedupStore: %s", err)
+	}
+	defer store.Close()
+
+	if err := store.Add("key"); err != nil {
+		t.Fatalf("Add: %s", err)
+	}
+	seen, err := store.Contains("key")
+	if err != nil {
+		t.Fatalf("Contains: %s", err)
+	}
+	if !seen {
+		t.Fatal("Contains(key) = false immediately after Add, want true")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	seen, err = store.Contains("key")
+	if err != nil {
+		t.Fatalf("Contains: %s", err)
+	}
+	if seen {
+		t.Fatal("Contains(key) = true after ttl elapsed, want false")
+	}
+}
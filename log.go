@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// Log is the process-wide structured logger, configured by ConfigureLog
+// from the -log-level flag. It emits JSON to stdout so the collector's
+// output can be shipped to a log aggregator.
+var Log = zerolog.New(os.Stdout).With().Timestamp().Logger()
+
+// ConfigureLog sets Log's minimum level from a -log-level flag value
+// (one of "debug", "info", "warn", "error"), defaulting to "info" for an
+// empty or unrecognised value.
+func ConfigureLog(level string) {
+	l, err := zerolog.ParseLevel(strings.ToLower(level))
+	if err != nil || level == "" {
+		l = zerolog.InfoLevel
+	}
+	zerolog.SetGlobalLevel(l)
+}
@@ -1,17 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/xml"
 	"errors"
 	"flag"
 	"fmt"
-	"github.com/AdRoll/goamz/aws"
-	"github.com/GeoNet/goamz/sqs"
 	"github.com/GeoNet/msg"
-	"log"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -73,20 +73,18 @@ func (m *HilltopMeasurement) Values() ([]HilltopValue, error) {
 	return values, nil
 }
 
-func (h *Hilltop) Observations(sites *HilltopSites, network, method string) ([]msg.Observation, error) {
+func (h *Hilltop) Observations(sites *HilltopSites, network, method string, cfg *Config) ([]msg.Observation, error) {
 	var msgs []msg.Observation
 	for _, m := range h.Measurement {
-		// check we know the source ...
-		s, ok := sites.Sites[m.SiteName]
+		siteID, typeID, methodID, errVal, ok := cfg.Resolve(network, m.SiteName, m.DataSource.Name, sites.Sites[m.SiteName], HilltopUnits[m.DataSource.Name], method, 0.0)
 		if !ok {
-			log.Printf("skipping unknown site: \"%s\"", m.SiteName)
-			continue
-		}
-
-		// check we know the type ...
-		t, ok := HilltopUnits[m.DataSource.Name]
-		if !ok {
-			log.Printf("skipping unknown data source: \"%s\"", m.DataSource.Name)
+			if siteID == "" {
+				Metrics.SkippedUnknown.WithLabelValues("site").Inc()
+				Log.Info().Str("site", m.SiteName).Msg("skipping unknown site")
+			} else {
+				Metrics.SkippedUnknown.WithLabelValues("data_source").Inc()
+				Log.Info().Str("dataSource", m.DataSource.Name).Msg("skipping unknown data source")
+			}
 			continue
 		}
 
@@ -98,13 +96,14 @@ func (h *Hilltop) Observations(sites *HilltopSites, network, method string) ([]m
 		for _, v := range values {
 			msgs = append(msgs, msg.Observation{
 				NetworkID: network,
-				SiteID:    s,
-				TypeID:    t,
-				MethodID:  method,
+				SiteID:    siteID,
+				TypeID:    typeID,
+				MethodID:  methodID,
 				DateTime:  v.Timestamp,
 				Value:     v.Reading,
-				Error:     0.0,
+				Error:     errVal,
 			})
+			Metrics.ObservationsTotal.Inc()
 		}
 	}
 
@@ -152,24 +151,104 @@ func (s *HilltopSites) Set(arg string) error {
 	return nil
 }
 
-func main() {
-	var Q *sqs.Queue
+// firstNonEmpty returns the first non-empty string, or "" if all are empty.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// runArgs bundles the flags run needs to construct the dedup store,
+// delivery sink, and config, and to process or watch the given files. It
+// exists only because main has too many flags to pass individually.
+type runArgs struct {
+	dryrun        bool
+	watch         bool
+	watchInterval time.Duration
+	watermarkFile string
+	dedupStore    string
+	dedupTTL      time.Duration
+
+	workers       int
+	batchSize     int
+	maxRetries    int
+	flushInterval time.Duration
+	dlq           string
+
+	config string
+
+	sink   string
+	queue  string
+	region string
+
+	profile string
+	roleARN string
+
+	method  string
+	network string
+	sites   *HilltopSites
+
+	files   []string
+	verbose bool
+}
 
+func main() {
 	// runtime settings
 	var verbose bool
-	flag.BoolVar(&verbose, "verbose", false, "make noise")
+	flag.BoolVar(&verbose, "verbose", false, "make noise (deprecated, use -log-level debug)")
+	var logLevel string
+	flag.StringVar(&logLevel, "log-level", "info", "log level: debug, info, warn, error")
+	var metricsAddr string
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "address to serve Prometheus /metrics on, e.g. :2112 (disabled if empty)")
 	var dryrun bool
 	flag.BoolVar(&dryrun, "dry-run", false, "don't actually send the messages")
+	var watch bool
+	flag.BoolVar(&watch, "watch", false, "poll the given sources forever instead of processing them once")
+	var watchInterval time.Duration
+	flag.DurationVar(&watchInterval, "watch-interval", 5*time.Minute, "how often to poll each source in -watch mode")
+	var watermarkFile string
+	flag.StringVar(&watermarkFile, "watermark", "", "file to persist the last processed timestamp per site/data source across restarts in -watch mode")
+	var dedupStore string
+	flag.StringVar(&dedupStore, "dedup-store", "", "store used to suppress already-delivered observations, e.g. mem://, bolt:///path (default mem://)")
+	var dedupTTL time.Duration
+	flag.DurationVar(&dedupTTL, "dedup-ttl", 24*time.Hour, "how long a dedup entry is remembered before it can be evicted, 0 disables eviction")
+
+	// delivery concurrency
+	var workers int
+	flag.IntVar(&workers, "workers", 1, "number of concurrent workers delivering to the sink")
+	var batchSize int
+	flag.IntVar(&batchSize, "batch-size", 1, "number of messages to batch per delivery attempt where the sink supports it")
+	var maxRetries int
+	flag.IntVar(&maxRetries, "max-retries", 3, "number of times to retry a failed delivery before dead-lettering it")
+	var flushInterval time.Duration
+	flag.DurationVar(&flushInterval, "flush-interval", defaultFlushInterval, "how long a worker waits for a partial batch to fill before sending it anyway")
+	var dlq string
+	flag.StringVar(&dlq, "dlq", "", "sink for messages that exhaust -max-retries, e.g. sqs://dlq-queue or file:///var/log/fits-dlq.ndjson")
+
+	// config-driven site/type/method mapping
+	var config string
+	flag.StringVar(&config, "config", "", "YAML config file with per-network site/type/method overrides, reloaded on SIGHUP")
+
+	// sink details
+	var sink string
+	flag.StringVar(&sink, "sink", "", "deliver messages to this sink, e.g. sqs://queue, kinesis://stream, sns://arn, http://host/path, file:///path, stdout://")
 
 	// amazon queue details
 	var region string
 	flag.StringVar(&region, "region", "", "provide AWS region, overides env variable \"AWS_REGION\"")
 	var queue string
-	flag.StringVar(&queue, "queue", "", "send messages to the SQS queue, overides env variable \"AWS_QUEUE\"")
+	flag.StringVar(&queue, "queue", "", "send messages to the SQS queue, overides env variable \"AWS_FITS_QUEUE\" (deprecated, use -sink sqs://queue)")
 	var key string
-	flag.StringVar(&key, "key", "", "AWS access key id, overrides env and credentials file (default profile)")
+	flag.StringVar(&key, "key", "", "AWS access key id (deprecated, set AWS_ACCESS_KEY_ID or use -profile instead)")
 	var secret string
-	flag.StringVar(&secret, "secret", "", "AWS secret key id, overrides env and credentials file (default profile)")
+	flag.StringVar(&secret, "secret", "", "AWS secret key id (deprecated, set AWS_SECRET_ACCESS_KEY or use -profile instead)")
+	var profile string
+	flag.StringVar(&profile, "profile", "", "named AWS shared config/credentials profile to use")
+	var roleARN string
+	flag.StringVar(&roleARN, "role-arn", "", "AWS role to assume for sink delivery, e.g. for cross-account SQS/Kinesis/SNS or IRSA on EKS")
 
 	// required fits external values
 	var method string
@@ -182,81 +261,267 @@ func main() {
 
 	flag.Parse()
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if verbose {
+		logLevel = "debug"
+	}
+	ConfigureLog(logLevel)
+
+	// -key/-secret are deprecated but still routed through the default
+	// credential chain via the environment, for backward compatibility.
+	if key != "" {
+		os.Setenv("AWS_ACCESS_KEY_ID", key)
+	}
+	if secret != "" {
+		os.Setenv("AWS_SECRET_ACCESS_KEY", secret)
+	}
+
+	if metricsAddr != "" {
+		go func() {
+			if err := ServeMetrics(metricsAddr); err != nil {
+				Log.Error().Err(err).Msg("metrics server stopped")
+			}
+		}()
+	}
+
 	// check required arguments
 	if method == "" {
-		log.Fatalf("no FITS method given")
+		Log.Fatal().Msg("no FITS method given")
 	}
 	if network == "" {
-		log.Fatalf("no FITS network given")
+		Log.Fatal().Msg("no FITS network given")
 	}
 
-	// setup aws sqs queue
-	if !dryrun {
-		if region == "" {
-			region = os.Getenv("AWS_FITS_REGION")
-			if region == "" {
-				log.Fatalf("unable to find region in environment or command line [AWS_FITS_REGION]")
+	if err := run(ctx, runArgs{
+		dryrun:        dryrun,
+		watch:         watch,
+		watchInterval: watchInterval,
+		watermarkFile: watermarkFile,
+		dedupStore:    dedupStore,
+		dedupTTL:      dedupTTL,
+		workers:       workers,
+		batchSize:     batchSize,
+		maxRetries:    maxRetries,
+		flushInterval: flushInterval,
+		dlq:           dlq,
+		config:        config,
+		sink:          sink,
+		queue:         queue,
+		region:        region,
+		profile:       profile,
+		roleARN:       roleARN,
+		method:        method,
+		network:       network,
+		sites:         sites,
+		files:         flag.Args(),
+		verbose:       verbose,
+	}); err != nil {
+		Log.Fatal().Err(err).Msg("fits-hilltop failed")
+	}
+}
+
+// run constructs the dedup store, delivery sink, and config from a, then
+// either watches a.files forever or processes them once, depending on
+// a.watch. Once a resource is constructed its cleanup is deferred, so
+// unlike main's own pre-flight argument checks, every failure from here
+// on is returned as an error rather than raised via Log.Fatal: Fatal
+// calls os.Exit directly, which would skip those deferred Closes and
+// silently drop whatever the delivery pool still had in flight.
+func run(ctx context.Context, a runArgs) error {
+	dedup, err := NewDedupStore(a.dedupStore, a.dedupTTL)
+	if err != nil {
+		return fmt.Errorf("unable to create dedup store: [%s]", err)
+	}
+	defer dedup.Close()
+
+	region := a.region
+	if region == "" {
+		region = firstNonEmpty(os.Getenv("AWS_FITS_REGION"), os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"))
+	}
+	// Shared by the delivery sink below and, in -watch mode, by s3://
+	// sources: both need the same AWS credentials/region.
+	awsCfg := AWSConfig{Region: region, Profile: a.profile, RoleARN: a.roleARN}
+
+	var S Sink
+	if !a.dryrun {
+		sink := a.sink
+		if sink == "" {
+			// fall back to the legacy -queue/-region flags for backward compatibility
+			queue := a.queue
+			if queue == "" {
+				queue = os.Getenv("AWS_FITS_QUEUE")
+				if queue == "" {
+					return errors.New("unable to find queue in environment or command line [AWS_FITS_QUEUE]")
+				}
 			}
+			sink = "sqs://" + queue
 		}
 
-		if queue == "" {
-			queue = os.Getenv("AWS_FITS_QUEUE")
-			if queue == "" {
-				log.Fatalf("unable to find queue in environment or command line [AWS_FITS_QUEUE]")
+		underlying, err := NewSink(ctx, sink, awsCfg)
+		if err != nil {
+			return fmt.Errorf("unable to create sink: [%s]", err)
+		}
+		defer underlying.Close()
+
+		var dlqSink Sink
+		if a.dlq != "" {
+			dlqSink, err = NewSink(ctx, a.dlq, awsCfg)
+			if err != nil {
+				return fmt.Errorf("unable to create dlq sink: [%s]", err)
 			}
+			defer dlqSink.Close()
 		}
 
-		// configure amazon ...
-		R := aws.GetRegion(region)
+		pool := NewDeliveryPool(ctx, underlying, dlqSink, a.workers, a.batchSize, a.maxRetries, a.flushInterval)
+		defer func() {
+			if err := pool.Close(); err != nil {
+				Log.Error().Err(err).Msg("unable to flush pending deliveries")
+			}
+		}()
+		S = pool
+	}
 
-		// fall through to env then credentials file
-		A, err := aws.GetAuth(key, secret, "", time.Now().Add(30*time.Minute))
-		if err != nil {
-			log.Fatalf("unable to get amazon auth: %s\n", err)
+	cfg, err := NewConfigStore(a.config)
+	if err != nil {
+		return fmt.Errorf("unable to load config: [%s]", err)
+	}
+	cfg.WatchReload()
+
+	emit := func(ctx context.Context, h *Hilltop) error {
+		return emitHilltop(ctx, h, a.sites, a.network, a.method, cfg, S, dedup, nil, a.dryrun, a.verbose)
+	}
+
+	if a.watch {
+		var wm *Watermark
+		if a.watermarkFile != "" {
+			wm, err = NewWatermark(a.watermarkFile)
+			if err != nil {
+				return fmt.Errorf("unable to load watermark: [%s]", err)
+			}
+			emit = func(ctx context.Context, h *Hilltop) error {
+				return emitHilltopTracked(ctx, h, a.sites, a.network, a.method, cfg, S, dedup, wm, a.dryrun, a.verbose)
+			}
+
+			// Save periodically rather than only once at the very end, so a
+			// crash or kill -9 loses at most one autosave interval's worth
+			// of progress instead of the entire run's.
+			go autosaveWatermark(ctx, wm, watermarkAutosaveInterval)
 		}
 
-		// create queue
-		S := sqs.New(A, R)
-		Q, err = S.GetQueue(queue)
-		if err != nil {
-			log.Fatalf("unable to get amazon queue: %s [%s/%s]\n", err, queue, region)
+		sources := make([]WatchSource, 0, len(a.files))
+		for _, f := range a.files {
+			sources = append(sources, WatchSource{URI: f, Interval: a.watchInterval})
+		}
+		if err := Watch(ctx, sources, awsCfg, emit); err != nil {
+			return fmt.Errorf("watch mode failed: [%s]", err)
 		}
+		if wm != nil {
+			if err := wm.Save(); err != nil {
+				return fmt.Errorf("unable to save watermark: [%s]", err)
+			}
+		}
+		return nil
 	}
 
 	// run through each provided file ...
-	for _, f := range flag.Args() {
-		if verbose {
-			log.Printf("processing: %s\n", f)
-		}
+	for _, f := range a.files {
+		Log.Debug().Str("file", f).Msg("processing")
 
 		// decode hilltop xml file
 		h, err := DecodeHilltopFile(f)
 		if err != nil {
-			log.Fatalf("unable to decode hilltop xml file: %s [%s]\n", f, err)
+			return fmt.Errorf("unable to decode hilltop xml file: %s [%s]", f, err)
+		}
+		Metrics.FilesDecoded.Inc()
+
+		if err := emit(ctx, h); err != nil {
+			return fmt.Errorf("unable to process hilltop file: [%s]", err)
 		}
 
-		// run through each observation
-		obs, err := h.Observations(sites, network, method)
+		Log.Debug().Str("file", f).Msg("completed")
+	}
+
+	return nil
+}
+
+// emitHilltop turns the measurements in h into FITS observations and
+// delivers them to sink, unless dryrun is set. Observations already
+// recorded in dedup are skipped. A newly-seen observation is only
+// recorded in dedup once its delivery is actually confirmed: when sink is
+// a *DeliveryPool, delivery is asynchronous, so dedup.Add happens inside
+// the callback the pool invokes once the observation's batch is
+// delivered (or dead-lettered, in which case it is left unseen); for any
+// other sink, delivery is synchronous and dedup.Add happens immediately
+// after a successful Send.
+//
+// onDelivered, if non-nil, is called with an observation once (and only
+// once) its delivery has been confirmed by the same rule, so callers can
+// hang other confirmation-gated bookkeeping - e.g. advancing a
+// watch-mode Watermark - off real delivery rather than mere enqueueing.
+func emitHilltop(ctx context.Context, h *Hilltop, sites *HilltopSites, network, method string, cfg *ConfigStore, sink Sink, dedup DedupStore, onDelivered func(msg.Observation), dryrun, verbose bool) error {
+	obs, err := h.Observations(sites, network, method, cfg.Get())
+	if err != nil {
+		return fmt.Errorf("unable to recover hilltop observations: [%s]", err)
+	}
+	for _, m := range obs {
+		key := ObservationKey(m)
+		seen, err := dedup.Contains(key)
 		if err != nil {
-			log.Fatalf("unable to recover hilltop observations: [%s]\n", err)
+			return fmt.Errorf("unable to check dedup store: [%s]", err)
 		}
-		for _, m := range obs {
-			mm, err := m.Encode()
-			if err != nil {
-				log.Fatalf("unable to encode hilltop msg: [%s]\n", err)
-			}
-			if verbose {
-				log.Println(string(mm))
-			}
-			if !dryrun {
-				_, err := Q.SendMessage(string(mm))
-				if err != nil {
-					log.Fatalf("unable to send hilltop msg: [%s]\n", err)
+		if seen {
+			Log.Debug().
+				Str("network", m.NetworkID).Str("site", m.SiteID).
+				Str("type", m.TypeID).Str("method", m.MethodID).
+				Time("dateTime", m.DateTime).
+				Msg("skipping duplicate observation")
+			continue
+		}
+
+		mm, err := m.Encode()
+		if err != nil {
+			return fmt.Errorf("unable to encode hilltop msg: [%s]", err)
+		}
+		Log.Debug().Msg(string(mm))
+		if dryrun {
+			continue
+		}
+
+		if pool, ok := sink.(*DeliveryPool); ok {
+			pool.EnqueueNotify(mm, func(delivered bool) {
+				if !delivered {
+					return
 				}
-			}
+				if err := dedup.Add(key); err != nil {
+					Log.Error().Err(err).Msg("unable to update dedup store")
+				}
+				if onDelivered != nil {
+					onDelivered(m)
+				}
+			})
+			continue
+		}
+
+		start := time.Now()
+		err = sink.Send(ctx, mm)
+		Metrics.SendLatency.Observe(time.Since(start).Seconds())
+		if err != nil {
+			return fmt.Errorf("unable to send hilltop msg: [%s]", err)
+		}
+		if err := dedup.Add(key); err != nil {
+			return fmt.Errorf("unable to update dedup store: [%s]", err)
 		}
-		if verbose {
-			log.Printf("completed\n")
+		if onDelivered != nil {
+			onDelivered(m)
 		}
 	}
+	if !dryrun {
+		if err := sink.Flush(); err != nil {
+			return fmt.Errorf("unable to flush sink: [%s]", err)
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"testing"
+)
+
+const testHilltopXML = `<?xml version="1.0"?>
+<Hilltop>
+<Agency>Test</Agency>
+<Measurement SiteName="SiteX">
+<DataSource Name="Air Temperature" NumItems="1">
+<Interpolation>Instant</Interpolation>
+</DataSource>
+<Data DateFormat="mowsecs">
+<V>01-Jan-24 00:00:00 12.3</V>
+</Data>
+</Measurement>
+</Hilltop>`
+
+// stubSink is a Sink whose Send always returns err (nil for success).
+type stubSink struct {
+	err error
+}
+
+func (s *stubSink) Send(ctx context.Context, b []byte) error { return s.err }
+func (s *stubSink) Flush() error                             { return nil }
+func (s *stubSink) Close() error                             { return nil }
+
+// TestEmitHilltop_PoolOnlyDedupsConfirmedDeliveries guards against
+// emitHilltop recording an observation as seen before a *DeliveryPool has
+// actually delivered it: a message that never leaves the pool (because
+// every delivery attempt fails and there is no DLQ) must stay undeduped
+// so a later replay of the same source can still deliver it.
+func TestEmitHilltop_PoolOnlyDedupsConfirmedDeliveries(t *testing.T) {
+	var h Hilltop
+	if err := xml.Unmarshal([]byte(testHilltopXML), &h); err != nil {
+		t.Fatalf("unable to parse test hilltop xml: %s", err)
+	}
+
+	sites := NewHilltopSites()
+	sites.Sites["SiteX"] = "123"
+	cfg, err := NewConfigStore("")
+	if err != nil {
+		t.Fatalf("unable to build config store: %s", err)
+	}
+
+	obs, err := h.Observations(sites, "network", "method", cfg.Get())
+	if err != nil {
+		t.Fatalf("unable to recover observations: %s", err)
+	}
+	if len(obs) != 1 {
+		t.Fatalf("expected 1 observation, got %d", len(obs))
+	}
+	key := ObservationKey(obs[0])
+
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name     string
+		sink     *stubSink
+		wantSeen bool
+	}{
+		{name: "failed delivery is not recorded as seen", sink: &stubSink{err: errors.New("boom")}, wantSeen: false},
+		{name: "confirmed delivery is recorded as seen", sink: &stubSink{}, wantSeen: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dedup := newMemDedupStore(0)
+			pool := NewDeliveryPool(ctx, tc.sink, nil, 1, 1, 0, 0)
+
+			if err := emitHilltop(ctx, &h, sites, "network", "method", cfg, pool, dedup, nil, false, false); err != nil {
+				t.Fatalf("emitHilltop: %s", err)
+			}
+			if err := pool.Close(); err != nil && tc.wantSeen {
+				t.Fatalf("pool.Close: %s", err)
+			}
+
+			seen, err := dedup.Contains(key)
+			if err != nil {
+				t.Fatalf("dedup.Contains: %s", err)
+			}
+			if seen != tc.wantSeen {
+				t.Fatalf("dedup.Contains(key) = %v, want %v", seen, tc.wantSeen)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics holds the Prometheus instrumentation for the ingestion
+// pipeline: how much was parsed, how much was skipped, and how delivery
+// to the sink behaved.
+var Metrics = struct {
+	FilesDecoded      prometheus.Counter
+	ObservationsTotal prometheus.Counter
+	SkippedUnknown    *prometheus.CounterVec
+	SendLatency       prometheus.Histogram
+	Retries           prometheus.Counter
+	DeadLetters       prometheus.Counter
+}{
+	FilesDecoded: prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "fits_hilltop_files_decoded_total",
+		Help: "Number of Hilltop XML files successfully decoded.",
+	}),
+	ObservationsTotal: prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "fits_hilltop_observations_parsed_total",
+		Help: "Number of observations parsed from Hilltop XML.",
+	}),
+	SkippedUnknown: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "fits_hilltop_observations_skipped_total",
+		Help: "Number of measurements skipped, labelled by reason.",
+	}, []string{"reason"}),
+	SendLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "fits_hilltop_sink_send_seconds",
+		Help:    "Latency of delivering a batch to the sink.",
+		Buckets: prometheus.DefBuckets,
+	}),
+	Retries: prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "fits_hilltop_retries_total",
+		Help: "Number of delivery attempts that were retried.",
+	}),
+	DeadLetters: prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "fits_hilltop_dead_letters_total",
+		Help: "Number of messages that exhausted their retries, whether routed to the dead-letter sink or dropped when none is configured.",
+	}),
+}
+
+func init() {
+	prometheus.MustRegister(
+		Metrics.FilesDecoded,
+		Metrics.ObservationsTotal,
+		Metrics.SkippedUnknown,
+		Metrics.SendLatency,
+		Metrics.Retries,
+		Metrics.DeadLetters,
+	)
+}
+
+// ServeMetrics starts an HTTP server exposing /metrics in Prometheus
+// format on addr. It runs until the process exits; callers that want a
+// managed lifecycle should run it in its own goroutine.
+func ServeMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return http.ListenAndServe(addr, mux)
+}
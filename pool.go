@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// BatchSender is implemented by sinks that can deliver several messages
+// in a single call, e.g. SQS's SendMessageBatch (up to 10 per call).
+// Sinks that don't implement it are sent to one message at a time.
+type BatchSender interface {
+	SendBatch(ctx context.Context, b [][]byte) error
+}
+
+// poolItem pairs an encoded message with the callback, if any, that wants
+// to know its eventual delivery outcome.
+type poolItem struct {
+	body     []byte
+	onResult func(delivered bool)
+}
+
+// defaultFlushInterval is how long a worker waits for a partial batch to
+// fill up before sending it anyway.
+const defaultFlushInterval = 5 * time.Second
+
+// DeliveryPool fans a stream of encoded messages out across a pool of
+// workers, batching up to batchSize messages per delivery attempt and
+// retrying transient failures with exponential backoff before giving up
+// and routing the message to a dead-letter sink. Each worker also sends its
+// pending batch, however small, on every tick of a flushInterval ticker, so
+// a partial batch waits at most flushInterval and a steady trickle of
+// messages too small to fill a batch does not sit undelivered indefinitely.
+type DeliveryPool struct {
+	ctx           context.Context
+	sink          Sink
+	dlq           Sink
+	batchSize     int
+	maxRetries    int
+	flushInterval time.Duration
+
+	queue chan poolItem
+	wg    sync.WaitGroup
+
+	mu   sync.Mutex
+	errs []error
+}
+
+// NewDeliveryPool starts workers goroutines pulling from an internally
+// buffered queue and delivering to sink, falling back to dlq (which may
+// be nil) for messages that exhaust maxRetries. ctx bounds every delivery
+// attempt so the pool can be shut down promptly. flushInterval <= 0 uses
+// defaultFlushInterval.
+func NewDeliveryPool(ctx context.Context, sink, dlq Sink, workers, batchSize, maxRetries int, flushInterval time.Duration) *DeliveryPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+
+	p := &DeliveryPool{
+		ctx:           ctx,
+		sink:          sink,
+		dlq:           dlq,
+		batchSize:     batchSize,
+		maxRetries:    maxRetries,
+		flushInterval: flushInterval,
+		queue:         make(chan poolItem, workers*batchSize),
+	}
+
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+
+	return p
+}
+
+// Enqueue hands a message to the pool for delivery, with no delivery
+// outcome reported back. It blocks if every worker is busy and the
+// internal queue is full.
+func (p *DeliveryPool) Enqueue(b []byte) {
+	p.queue <- poolItem{body: b}
+}
+
+// EnqueueNotify behaves like Enqueue, but calls onResult, if non-nil,
+// once the message's delivery outcome is known: delivered is true once
+// its batch is confirmed sent to sink, and false if the batch was
+// instead routed to dlq (or dropped, if dlq is nil).
+func (p *DeliveryPool) EnqueueNotify(b []byte, onResult func(delivered bool)) {
+	p.queue <- poolItem{body: b, onResult: onResult}
+}
+
+// Send implements Sink by enqueueing b for asynchronous delivery.
+// Delivery errors surface from Close, not from Send; ctx is not the one
+// used for the eventual delivery attempt, since that happens later on a
+// worker goroutine bound to the pool's own context.
+func (p *DeliveryPool) Send(ctx context.Context, b []byte) error {
+	p.Enqueue(b)
+	return nil
+}
+
+// Flush implements Sink. Delivery through a DeliveryPool is asynchronous,
+// so there is nothing to flush until Close drains the queue.
+func (p *DeliveryPool) Flush() error { return nil }
+
+// Close stops accepting new messages, waits for every queued message to
+// be delivered (or dead-lettered), and flushes the underlying sinks. It
+// returns the first error encountered, if any.
+func (p *DeliveryPool) Close() error {
+	close(p.queue)
+	p.wg.Wait()
+
+	if err := p.sink.Flush(); err != nil {
+		p.recordErr(err)
+	}
+	if p.dlq != nil {
+		if err := p.dlq.Flush(); err != nil {
+			p.recordErr(err)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.errs) > 0 {
+		return p.errs[0]
+	}
+	return nil
+}
+
+func (p *DeliveryPool) recordErr(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.errs = append(p.errs, err)
+}
+
+func (p *DeliveryPool) work() {
+	defer p.wg.Done()
+
+	batch := make([]poolItem, 0, p.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		p.deliver(batch)
+		batch = batch[:0]
+	}
+
+	t := time.NewTicker(p.flushInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case item, ok := <-p.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, item)
+			if len(batch) >= p.batchSize {
+				flush()
+			}
+		case <-t.C:
+			flush()
+		}
+	}
+}
+
+// deliver attempts to send batch, retrying with exponential backoff and
+// jitter, and routes it to the dead-letter sink on permanent failure.
+// Either way, it reports each item's outcome through its own onResult
+// callback: a message is only reported delivered once sendWithRetry has
+// actually confirmed it, never merely because it was enqueued.
+func (p *DeliveryPool) deliver(batch []poolItem) {
+	bodies := make([][]byte, len(batch))
+	for i, item := range batch {
+		bodies[i] = item.body
+	}
+
+	start := time.Now()
+	err := p.sendWithRetry(bodies)
+	Metrics.SendLatency.Observe(time.Since(start).Seconds())
+	if err == nil {
+		p.report(batch, true)
+		return
+	}
+
+	Log.Error().Err(err).Int("batchSize", len(batch)).Int("maxRetries", p.maxRetries).Msg("giving up on batch")
+	Metrics.DeadLetters.Add(float64(len(batch)))
+	if p.dlq == nil {
+		p.recordErr(err)
+		p.report(batch, false)
+		return
+	}
+	for _, item := range batch {
+		if dlqErr := p.dlq.Send(p.ctx, item.body); dlqErr != nil {
+			p.recordErr(fmt.Errorf("unable to dead-letter message: %s", dlqErr))
+		}
+	}
+	p.report(batch, false)
+}
+
+// report invokes each item's onResult callback, skipping those enqueued
+// without one.
+func (p *DeliveryPool) report(batch []poolItem, delivered bool) {
+	for _, item := range batch {
+		if item.onResult != nil {
+			item.onResult(delivered)
+		}
+	}
+}
+
+func (p *DeliveryPool) sendWithRetry(batch [][]byte) error {
+	var err error
+	for attempt := 0; attempt <= p.maxRetries; attempt++ {
+		if attempt > 0 {
+			Metrics.Retries.Inc()
+			select {
+			case <-time.After(backoff(attempt)):
+			case <-p.ctx.Done():
+				return p.ctx.Err()
+			}
+		}
+
+		if bs, ok := p.sink.(BatchSender); ok {
+			err = bs.SendBatch(p.ctx, batch)
+		} else {
+			err = sendEach(p.ctx, p.sink, batch)
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func sendEach(ctx context.Context, sink Sink, batch [][]byte) error {
+	for _, b := range batch {
+		if err := sink.Send(ctx, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backoff returns an exponential delay with jitter for the given retry
+// attempt (1-indexed), capped at 30s.
+func backoff(attempt int) time.Duration {
+	shift := attempt - 1
+	if shift > 20 {
+		shift = 20 // keeps the shift from overflowing; 2^20 * 100ms is already past the cap
+	}
+	base := time.Duration(1<<uint(shift)) * 100 * time.Millisecond
+	if base > 30*time.Second {
+		base = 30 * time.Second
+	}
+	jitter := time.Duration(rand.Int63n(int64(base) + 1))
+	return base/2 + jitter/2
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/segmentio/kafka-go"
+)
+
+// Sink delivers encoded observation messages to a downstream destination.
+// Implementations should be safe to reuse across many calls to Send.
+type Sink interface {
+	// Send delivers a single encoded message, honouring ctx's deadline so
+	// the process can shut down promptly.
+	Send(ctx context.Context, b []byte) error
+
+	// Flush delivers any messages buffered by Send.
+	Flush() error
+
+	// Close releases any resources held by the sink.
+	Close() error
+}
+
+// AWSConfig carries the credential and region settings shared by every
+// AWS-backed sink.
+type AWSConfig struct {
+	Region  string
+	Profile string
+	RoleARN string
+}
+
+// NewSink builds a Sink from a scheme://... URI, e.g.:
+//
+//	sqs://queue-name
+//	kinesis://stream-name
+//	sns://topic-arn
+//	kafka://broker:9092/topic
+//	http://host/path
+//	file:///var/log/fits.ndjson
+//	stdout://
+func NewSink(ctx context.Context, raw string, awsCfg AWSConfig) (Sink, error) {
+	// An ARN's colons read as an invalid port to url.Parse, so sns:// is
+	// taken apart by hand.
+	if strings.HasPrefix(raw, "sns://") {
+		return newSNSSink(ctx, strings.TrimPrefix(raw, "sns://"), awsCfg)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sink: %s [%s]", raw, err)
+	}
+
+	switch u.Scheme {
+	case "sqs":
+		return newSQSSink(ctx, u.Host, awsCfg)
+	case "kinesis":
+		return newKinesisSink(ctx, u.Host, awsCfg)
+	case "sns":
+		return newSNSSink(ctx, u.Host, awsCfg)
+	case "kafka":
+		return newKafkaSink(u.Host, strings.TrimPrefix(u.Path, "/"))
+	case "http", "https":
+		return newHTTPSink(raw)
+	case "file":
+		return newFileSink(u.Path)
+	case "stdout":
+		return newStdoutSink(), nil
+	default:
+		return nil, fmt.Errorf("unknown sink scheme: %q", u.Scheme)
+	}
+}
+
+// loadAWSConfig resolves credentials via the default chain (env -> shared
+// config -> EC2 IMDSv2 -> ECS container), optionally narrowed to a named
+// profile and/or exchanged for an assumed role (for IRSA on EKS or
+// cross-account delivery).
+func loadAWSConfig(ctx context.Context, c AWSConfig) (aws.Config, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if c.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(c.Region))
+	}
+	if c.Profile != "" {
+		opts = append(opts, awsconfig.WithSharedConfigProfile(c.Profile))
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("unable to load aws config: %s", err)
+	}
+
+	if c.RoleARN != "" {
+		cfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), c.RoleARN))
+	}
+
+	return cfg, nil
+}
+
+// sqsSink delivers messages one at a time, or batched via SendBatch, to
+// an SQS queue.
+type sqsSink struct {
+	client   *sqs.Client
+	queueURL string
+}
+
+func newSQSSink(ctx context.Context, queue string, awsCfg AWSConfig) (Sink, error) {
+	cfg, err := loadAWSConfig(ctx, awsCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	client := sqs.NewFromConfig(cfg)
+
+	out, err := client.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{QueueName: aws.String(queue)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get amazon queue: %s [%s/%s]", err, queue, awsCfg.Region)
+	}
+
+	return &sqsSink{client: client, queueURL: aws.ToString(out.QueueUrl)}, nil
+}
+
+func (s *sqsSink) Send(ctx context.Context, b []byte) error {
+	_, err := s.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(s.queueURL),
+		MessageBody: aws.String(string(b)),
+	})
+	return err
+}
+
+// sqsBatchLimit is the maximum number of messages SQS accepts in a
+// single SendMessageBatch call.
+const sqsBatchLimit = 10
+
+// SendBatch delivers up to sqsBatchLimit messages per SendMessageBatch
+// call, chunking larger batches.
+func (s *sqsSink) SendBatch(ctx context.Context, batch [][]byte) error {
+	for len(batch) > 0 {
+		n := sqsBatchLimit
+		if n > len(batch) {
+			n = len(batch)
+		}
+
+		entries := make([]types.SendMessageBatchRequestEntry, n)
+		for i, b := range batch[:n] {
+			entries[i] = types.SendMessageBatchRequestEntry{
+				Id:          aws.String(fmt.Sprintf("%d", i)),
+				MessageBody: aws.String(string(b)),
+			}
+		}
+
+		out, err := s.client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+			QueueUrl: aws.String(s.queueURL),
+			Entries:  entries,
+		})
+		if err != nil {
+			return err
+		}
+		if len(out.Failed) > 0 {
+			return fmt.Errorf("%d of %d messages failed in batch: %s", len(out.Failed), n, aws.ToString(out.Failed[0].Message))
+		}
+
+		batch = batch[n:]
+	}
+	return nil
+}
+
+func (s *sqsSink) Flush() error { return nil }
+func (s *sqsSink) Close() error { return nil }
+
+// kinesisSink delivers messages as records to a Kinesis stream. Records
+// have no natural partition key, so each is keyed by its own SHA1 hash,
+// which spreads messages evenly across shards while keeping identical
+// payloads on the same shard.
+type kinesisSink struct {
+	client *kinesis.Client
+	stream string
+}
+
+func newKinesisSink(ctx context.Context, stream string, awsCfg AWSConfig) (Sink, error) {
+	if stream == "" {
+		return nil, fmt.Errorf("kinesis sink requires a stream name: kinesis://stream")
+	}
+
+	cfg, err := loadAWSConfig(ctx, awsCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kinesisSink{client: kinesis.NewFromConfig(cfg), stream: stream}, nil
+}
+
+func (s *kinesisSink) Send(ctx context.Context, b []byte) error {
+	_, err := s.client.PutRecord(ctx, &kinesis.PutRecordInput{
+		StreamName:   aws.String(s.stream),
+		Data:         b,
+		PartitionKey: aws.String(partitionKey(b)),
+	})
+	return err
+}
+
+func (s *kinesisSink) Flush() error { return nil }
+func (s *kinesisSink) Close() error { return nil }
+
+// partitionKey derives a Kinesis partition key from a message body.
+func partitionKey(b []byte) string {
+	h := sha1.Sum(b)
+	return hex.EncodeToString(h[:])
+}
+
+// snsSink publishes messages to an SNS topic.
+type snsSink struct {
+	client   *sns.Client
+	topicARN string
+}
+
+func newSNSSink(ctx context.Context, topicARN string, awsCfg AWSConfig) (Sink, error) {
+	if topicARN == "" {
+		return nil, fmt.Errorf("sns sink requires a topic arn: sns://arn:aws:sns:...")
+	}
+
+	cfg, err := loadAWSConfig(ctx, awsCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &snsSink{client: sns.NewFromConfig(cfg), topicARN: topicARN}, nil
+}
+
+func (s *snsSink) Send(ctx context.Context, b []byte) error {
+	_, err := s.client.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(s.topicARN),
+		Message:  aws.String(string(b)),
+	})
+	return err
+}
+
+func (s *snsSink) Flush() error { return nil }
+func (s *snsSink) Close() error { return nil }
+
+// kafkaSink publishes messages to a Kafka topic.
+type kafkaSink struct {
+	w *kafka.Writer
+}
+
+func newKafkaSink(broker, topic string) (Sink, error) {
+	if broker == "" {
+		return nil, fmt.Errorf("kafka sink requires a broker: kafka://broker:9092/topic")
+	}
+	if topic == "" {
+		return nil, fmt.Errorf("kafka sink requires a topic: kafka://broker:9092/topic")
+	}
+
+	return &kafkaSink{w: &kafka.Writer{
+		Addr:                   kafka.TCP(broker),
+		Topic:                  topic,
+		Balancer:               &kafka.LeastBytes{},
+		AllowAutoTopicCreation: true,
+	}}, nil
+}
+
+func (s *kafkaSink) Send(ctx context.Context, b []byte) error {
+	return s.w.WriteMessages(ctx, kafka.Message{Value: b})
+}
+
+// SendBatch writes every message in batch in a single WriteMessages call,
+// which kafka-go itself batches per-partition.
+func (s *kafkaSink) SendBatch(ctx context.Context, batch [][]byte) error {
+	msgs := make([]kafka.Message, len(batch))
+	for i, b := range batch {
+		msgs[i] = kafka.Message{Value: b}
+	}
+	return s.w.WriteMessages(ctx, msgs...)
+}
+
+func (s *kafkaSink) Flush() error { return nil }
+func (s *kafkaSink) Close() error { return s.w.Close() }
+
+// httpSink POSTs each message to a fixed HTTP endpoint.
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPSink(rawurl string) (Sink, error) {
+	return &httpSink{url: rawurl, client: http.DefaultClient}, nil
+}
+
+func (s *httpSink) Send(ctx context.Context, b []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, strings.NewReader(string(b)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status from %s: %s", s.url, resp.Status)
+	}
+
+	return nil
+}
+
+func (s *httpSink) Flush() error { return nil }
+func (s *httpSink) Close() error { return nil }
+
+// fileSink appends newline-delimited messages to a local file.
+type fileSink struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+func newFileSink(path string) (Sink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open sink file: %s [%s]", path, err)
+	}
+
+	return &fileSink{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (s *fileSink) Send(ctx context.Context, b []byte) error {
+	if _, err := s.w.Write(b); err != nil {
+		return err
+	}
+	return s.w.WriteByte('\n')
+}
+
+func (s *fileSink) Flush() error { return s.w.Flush() }
+
+func (s *fileSink) Close() error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	return s.f.Close()
+}
+
+// stdoutSink writes messages to standard out, mainly for -dry-run and debugging.
+type stdoutSink struct {
+	w *bufio.Writer
+}
+
+func newStdoutSink() Sink {
+	return &stdoutSink{w: bufio.NewWriter(os.Stdout)}
+}
+
+func (s *stdoutSink) Send(ctx context.Context, b []byte) error {
+	if _, err := s.w.Write(b); err != nil {
+		return err
+	}
+	return s.w.WriteByte('\n')
+}
+
+func (s *stdoutSink) Flush() error { return s.w.Flush() }
+func (s *stdoutSink) Close() error { return s.Flush() }
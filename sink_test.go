@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestNewSink_Dispatch checks NewSink's scheme dispatch for the backends
+// that don't require live network credentials to construct.
+func TestNewSink_Dispatch(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "out.ndjson")
+		s, err := NewSink(ctx, "file://"+path, AWSConfig{})
+		if err != nil {
+			t.Fatalf("NewSink: %s", err)
+		}
+		defer s.Close()
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("file sink did not create %s: %s", path, err)
+		}
+	})
+
+	t.Run("stdout", func(t *testing.T) {
+		s, err := NewSink(ctx, "stdout://", AWSConfig{})
+		if err != nil {
+			t.Fatalf("NewSink: %s", err)
+		}
+		defer s.Close()
+		if _, ok := s.(*stdoutSink); !ok {
+			t.Errorf("NewSink(stdout://) = %T, want *stdoutSink", s)
+		}
+	})
+
+	t.Run("kafka", func(t *testing.T) {
+		s, err := NewSink(ctx, "kafka://broker:9092/topic", AWSConfig{})
+		if err != nil {
+			t.Fatalf("NewSink: %s", err)
+		}
+		defer s.Close()
+		if _, ok := s.(*kafkaSink); !ok {
+			t.Errorf("NewSink(kafka://...) = %T, want *kafkaSink", s)
+		}
+	})
+
+	t.Run("kafka missing topic", func(t *testing.T) {
+		if _, err := NewSink(ctx, "kafka://broker:9092", AWSConfig{}); err == nil {
+			t.Fatal("NewSink(kafka://broker with no topic): expected an error, got nil")
+		}
+	})
+
+	t.Run("unknown scheme", func(t *testing.T) {
+		if _, err := NewSink(ctx, "carrier-pigeon://nowhere", AWSConfig{}); err == nil {
+			t.Fatal("NewSink(unknown scheme): expected an error, got nil")
+		}
+	})
+}
+
+// TestNewDedupStore_Dispatch checks NewDedupStore's scheme dispatch,
+// including its mem:// default and bolt:// path requirement.
+func TestNewDedupStore_Dispatch(t *testing.T) {
+	t.Run("default is mem", func(t *testing.T) {
+		s, err := NewDedupStore("", 0)
+		if err != nil {
+			t.Fatalf("NewDedupStore: %s", err)
+		}
+		defer s.Close()
+		if _, ok := s.(*memDedupStore); !ok {
+			t.Errorf("NewDedupStore(\"\") = %T, want *memDedupStore", s)
+		}
+	})
+
+	t.Run("bolt requires a path", func(t *testing.T) {
+		if _, err := NewDedupStore("bolt://", 0); err == nil {
+			t.Fatal("NewDedupStore(bolt:// with no path): expected an error, got nil")
+		}
+	})
+
+	t.Run("bolt with a path", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dedup.db")
+		s, err := NewDedupStore("bolt://"+path, 0)
+		if err != nil {
+			t.Fatalf("NewDedupStore: %s", err)
+		}
+		defer s.Close()
+		if _, ok := s.(*boltDedupStore); !ok {
+			t.Errorf("NewDedupStore(bolt://...) = %T, want *boltDedupStore", s)
+		}
+	})
+
+	t.Run("unknown scheme", func(t *testing.T) {
+		if _, err := NewDedupStore("carrier-pigeon://nowhere", 0); err == nil {
+			t.Fatal("NewDedupStore(unknown scheme): expected an error, got nil")
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestWatermark_BeforeAdvance checks that Before reports a timestamp as
+// already processed only once Advance has recorded something at or after
+// it, and that Advance never lets the watermark move backwards.
+func TestWatermark_BeforeAdvance(t *testing.T) {
+	w := &Watermark{seen: make(map[string]time.Time)}
+
+	t0 := time.Now()
+	t1 := t0.Add(time.Minute)
+
+	if w.Before("SiteX", "Rainfall", t0) {
+		t.Fatal("Before on an empty watermark = true, want false")
+	}
+
+	w.Advance("SiteX", "Rainfall", t1)
+
+	if !w.Before("SiteX", "Rainfall", t0) {
+		t.Error("Before(t0) after Advance(t1) = false, want true (t0 is before the watermark)")
+	}
+	if !w.Before("SiteX", "Rainfall", t1) {
+		t.Error("Before(t1) after Advance(t1) = false, want true (t1 is at the watermark)")
+	}
+	if w.Before("SiteX", "Rainfall", t1.Add(time.Second)) {
+		t.Error("Before(t1+1s) after Advance(t1) = true, want false (after the watermark)")
+	}
+	if w.Before("SiteY", "Rainfall", t1) {
+		t.Error("Before for an unrelated (site, dataSource) = true, want false")
+	}
+
+	w.Advance("SiteX", "Rainfall", t0)
+	if !w.Before("SiteX", "Rainfall", t1) {
+		t.Error("Advance with an older timestamp moved the watermark backwards")
+	}
+}
+
+// TestWatermark_SaveLoadRoundTrip checks that a saved watermark can be
+// reloaded via NewWatermark with the same (site, dataSource) timestamps.
+func TestWatermark_SaveLoadRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watermark")
+
+	w := &Watermark{path: path, seen: make(map[string]time.Time)}
+	t1 := time.Now().Truncate(time.Second)
+	w.Advance("SiteX", "Rainfall", t1)
+
+	if err := w.Save(); err != nil {
+		t.Fatalf("Save: %s", err)
+	}
+
+	loaded, err := NewWatermark(path)
+	if err != nil {
+		t.Fatalf("NewWatermark: %s", err)
+	}
+	if !loaded.Before("SiteX", "Rainfall", t1) {
+		t.Error("reloaded watermark does not recognise the saved timestamp")
+	}
+}